@@ -0,0 +1,22 @@
+package listener
+
+import (
+	"bufio"
+	"net"
+)
+
+//peekedConn wraps a net.Conn whose leading bytes have already been read into a bufio.Reader
+//(e.g. while parsing a PROXY protocol header or sniffing a TLS ClientHello), so those bytes are
+//replayed to subsequent reads instead of being lost.
+type peekedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func newPeekedConn(c net.Conn, r *bufio.Reader) *peekedConn {
+	return &peekedConn{Conn: c, r: r}
+}
+
+func (c *peekedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
@@ -3,16 +3,137 @@ package listener
 import (
 	"crypto/tls"
 	"errors"
+	"fmt"
+	"io"
 	"net"
+	"sync"
+	"time"
 )
 
+//ListenerOptions configures the socket-level behavior applied to each connection a TCPListener or
+//TLSListener accepts, since trudy otherwise inherits only OS defaults and can't detect a peer that
+//silently stopped responding behind a NAT or cloud load balancer.
+type ListenerOptions struct {
+	KeepAlive       bool
+	KeepAlivePeriod time.Duration
+
+	//Linger, if non-nil, is applied via SetLinger; see (*net.TCPConn).SetLinger for semantics.
+	Linger *int
+
+	NoDelay bool
+
+	//ReadTimeout/WriteTimeout, if non-zero, are applied to the returned net.Conn via SetDeadline
+	//and refreshed on every Read/Write the pipe layer performs, acting as a sliding idle timeout.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+}
+
+func (o *ListenerOptions) apply(c *net.TCPConn) error {
+	if o == nil {
+		return nil
+	}
+	if o.KeepAlive {
+		if err := c.SetKeepAlive(true); err != nil {
+			return err
+		}
+		if o.KeepAlivePeriod > 0 {
+			if err := c.SetKeepAlivePeriod(o.KeepAlivePeriod); err != nil {
+				return err
+			}
+		}
+	}
+	if o.Linger != nil {
+		if err := c.SetLinger(*o.Linger); err != nil {
+			return err
+		}
+	}
+	if o.NoDelay {
+		if err := c.SetNoDelay(true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *ListenerOptions) wrap(conn net.Conn) net.Conn {
+	if o == nil || (o.ReadTimeout == 0 && o.WriteTimeout == 0) {
+		return conn
+	}
+	return &deadlineConn{Conn: conn, readTimeout: o.ReadTimeout, writeTimeout: o.WriteTimeout}
+}
+
+//deadlineConn refreshes a read and/or write deadline on the wrapped conn before every Read/Write,
+//turning ListenerOptions.ReadTimeout/WriteTimeout into a sliding idle timeout rather than a single
+//deadline for the whole connection. It tracks deadlines set explicitly by a caller (e.g.
+//ProxyProtoListener or MuxListener peeking a header through this same conn with their own, usually
+//much stricter, deadline) and leaves those alone rather than overwriting them with the sliding
+//timeout, so a wrapper higher up the TrudyListener chain keeps the deadline it asked for.
+type deadlineConn struct {
+	net.Conn
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+
+	mu                sync.Mutex
+	externalReadUntil time.Time
+	externalWriteUntil time.Time
+}
+
+func (c *deadlineConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.externalReadUntil = t
+	c.mu.Unlock()
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *deadlineConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.externalWriteUntil = t
+	c.mu.Unlock()
+	return c.Conn.SetWriteDeadline(t)
+}
+
+func (c *deadlineConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.externalReadUntil = t
+	c.externalWriteUntil = t
+	c.mu.Unlock()
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *deadlineConn) Read(b []byte) (int, error) {
+	if c.readTimeout > 0 && !c.hasExternalDeadline(&c.externalReadUntil) {
+		if err := c.Conn.SetReadDeadline(time.Now().Add(c.readTimeout)); err != nil {
+			return 0, err
+		}
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *deadlineConn) Write(b []byte) (int, error) {
+	if c.writeTimeout > 0 && !c.hasExternalDeadline(&c.externalWriteUntil) {
+		if err := c.Conn.SetWriteDeadline(time.Now().Add(c.writeTimeout)); err != nil {
+			return 0, err
+		}
+	}
+	return c.Conn.Write(b)
+}
+
+//hasExternalDeadline reports whether a caller-imposed deadline (set via SetReadDeadline,
+//SetWriteDeadline, or SetDeadline) is still in effect, in which case the sliding idle timeout
+//must not re-arm over it.
+func (c *deadlineConn) hasExternalDeadline(until *time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return !until.IsZero() && time.Now().Before(*until)
+}
+
 //The TrudyListener interface is used to listen for incoming connections and accept them. This is almost
 //the same as the typical Listener interface, except a net.Conn must be returned for Accept. This enables
 //Trudy to grab the original destination IP address from the kernel.
 type TrudyListener interface {
-	//TODO: Listen should take two strings: "tcp" or "udp" and a port to listen on.
-	//This parameter could create a Listener for both tcp and udp.
-	Listen(port string, cert *tls.Config)
+	//Listen binds the listener to address on the given network ("tcp", "tcp4", "tcp6", "tls",
+	//"udp", "unix", or "unixpacket"). cert is only consulted by listeners that terminate TLS.
+	Listen(network, address string, cert *tls.Config) error
 
 	//Accept returns a generic net.Conn and the file descriptor of the socket.
 	Accept() (int, net.Conn, error)
@@ -21,18 +142,50 @@ type TrudyListener interface {
 	Close() error
 }
 
+//NewListener is a factory that constructs the TrudyListener implementation matching network,
+//so callers (e.g. the main program driving listeners from config) don't need a hard-coded type
+//switch for every network trudy supports. opts is applied to each connection the listener
+//accepts; it is ignored by listener types that don't accept raw TCP sockets (udp, unix). upstream
+//is only consulted for "unix"/"unixpacket" listeners, which have no original destination to
+//forward to and so need it set on UnixListener.Upstream before Forward can be used; it is ignored
+//for every other network.
+func NewListener(network, addr string, tlsCfg *tls.Config, opts *ListenerOptions, upstream string) (TrudyListener, error) {
+	var tl TrudyListener
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		tl = &TCPListener{Options: opts}
+	case "tls":
+		tl = &TLSListener{Options: opts}
+	case "udp":
+		tl = &UDPListener{}
+	case "unix", "unixpacket":
+		tl = &UnixListener{Upstream: upstream}
+	default:
+		return nil, fmt.Errorf("listener: unsupported network %q", network)
+	}
+	if err := tl.Listen(network, addr, tlsCfg); err != nil {
+		return nil, err
+	}
+	return tl, nil
+}
+
 //The TCPListener struct implements the TrudyListener interface and handles TCP connections.
 type TCPListener struct {
 	Listener *net.TCPListener
+	Options  *ListenerOptions
 }
 
-func (tl *TCPListener) Listen(port string, _ *tls.Config) {
-	tcpAddr, _ := net.ResolveTCPAddr("tcp", port)
-	tcpListener, err := net.ListenTCP("tcp", tcpAddr)
+func (tl *TCPListener) Listen(network, address string, _ *tls.Config) error {
+	tcpAddr, err := net.ResolveTCPAddr(network, address)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	tcpListener, err := net.ListenTCP(network, tcpAddr)
+	if err != nil {
+		return err
 	}
 	tl.Listener = tcpListener
+	return nil
 }
 
 func (tl *TCPListener) Accept() (fd int, conn net.Conn, err error) {
@@ -40,9 +193,14 @@ func (tl *TCPListener) Accept() (fd int, conn net.Conn, err error) {
 	if err != nil {
 		return
 	}
+	if err = tl.Options.apply(cpointer); err != nil {
+		cpointer.Close()
+		return
+	}
 	file, err := cpointer.File()
 	fd = int(file.Fd())
 	conn, err = net.FileConn(file)
+	conn = tl.Options.wrap(conn)
 	return
 }
 
@@ -54,6 +212,7 @@ func (tl *TCPListener) Close() error {
 type TLSListener struct {
 	Listener *net.TCPListener
 	Config   *tls.Config
+	Options  *ListenerOptions
 }
 
 func (tl *TLSListener) Accept() (fd int, conn net.Conn, err error) {
@@ -61,6 +220,10 @@ func (tl *TLSListener) Accept() (fd int, conn net.Conn, err error) {
 	if err != nil {
 		return
 	}
+	if err = tl.Options.apply(cpointer); err != nil {
+		cpointer.Close()
+		return
+	}
 	file, err := cpointer.File()
 	if err != nil {
 		return
@@ -70,21 +233,25 @@ func (tl *TLSListener) Accept() (fd int, conn net.Conn, err error) {
 	if err != nil {
 		return
 	}
-	conn = tls.Server(fconn, tl.Config)
+	conn = tl.Options.wrap(tls.Server(fconn, tl.Config))
 	return
 }
 
-func (tl *TLSListener) Listen(port string, config *tls.Config) {
-	tcpAddr, _ := net.ResolveTCPAddr("tcp", port)
-	if len(config.Certificates) == 0 {
-		panic(errors.New("tls.Listen: no certificates in configuration"))
+func (tl *TLSListener) Listen(network, address string, config *tls.Config) error {
+	if config == nil || len(config.Certificates) == 0 {
+		return errors.New("tls.Listen: no certificates in configuration")
+	}
+	tcpAddr, err := net.ResolveTCPAddr("tcp", address)
+	if err != nil {
+		return err
 	}
 	tcpListener, err := net.ListenTCP("tcp", tcpAddr)
 	if err != nil {
-		panic(err)
+		return err
 	}
 	tl.Listener = tcpListener
 	tl.Config = config
+	return nil
 }
 
 func (tl *TLSListener) Close() error {
@@ -95,8 +262,13 @@ type UDPListener struct {
 	Laddr *net.UDPAddr
 }
 
-func (ul *UDPListener) Listen(port string, _ *tls.Config) {
-	ul.Laddr, _ = net.ResolveUDPAddr("udp", port)
+func (ul *UDPListener) Listen(network, address string, _ *tls.Config) error {
+	laddr, err := net.ResolveUDPAddr(network, address)
+	if err != nil {
+		return err
+	}
+	ul.Laddr = laddr
+	return nil
 }
 
 func (ul *UDPListener) Accept() (fd int, conn net.Conn, err error) {
@@ -120,3 +292,93 @@ func (ul *UDPListener) Accept() (fd int, conn net.Conn, err error) {
 func (ul *UDPListener) Close() (err error) {
 	return nil
 }
+
+//UnixListener struct implements the TrudyListener interface and handles Unix domain socket
+//connections, including "unixpacket" for seqpacket IPC such as dbus. Abstract-namespace sockets
+//(the Linux convention where the name is prefixed with a NUL byte rather than a path) can be
+//given either as "\x00name" or as "@name": on Linux Go's net package turns a leading '@' into
+//the NUL byte of an abstract name. Since a Unix socket has no
+//concept of an "original destination" the way SO_ORIGINAL_DST provides for TCP, the upstream to
+//forward accepted connections to must be supplied out of band via Upstream.
+type UnixListener struct {
+	Listener *net.UnixListener
+	Network  string
+	//Upstream is the address Forward dials for each accepted connection: either another Unix
+	//socket path (e.g. "/var/run/docker.sock") or a "host:port" TCP endpoint.
+	Upstream string
+}
+
+func (ul *UnixListener) Listen(network, address string, _ *tls.Config) error {
+	unixAddr, err := net.ResolveUnixAddr(network, address)
+	if err != nil {
+		return err
+	}
+	unixListener, err := net.ListenUnix(network, unixAddr)
+	if err != nil {
+		return err
+	}
+	ul.Listener = unixListener
+	ul.Network = network
+	return nil
+}
+
+//Accept returns the accepted connection's own file descriptor as a sentinel, since Unix sockets
+//have no original destination to recover from the kernel. Callers pass the returned conn to
+//Forward to proxy it to ul.Upstream.
+func (ul *UnixListener) Accept() (fd int, conn net.Conn, err error) {
+	cpointer, err := ul.Listener.AcceptUnix()
+	if err != nil {
+		return
+	}
+	file, err := cpointer.File()
+	if err != nil {
+		return
+	}
+	fd = int(file.Fd())
+	conn, err = net.FileConn(file)
+	return
+}
+
+func (ul *UnixListener) Close() error {
+	return ul.Listener.Close()
+}
+
+//DialUpstream dials ul.Upstream, the configured forwarding target for this listener. Upstream is
+//treated as a TCP "host:port" endpoint when it parses as one, and as a Unix socket path otherwise.
+func (ul *UnixListener) DialUpstream() (net.Conn, error) {
+	if ul.Upstream == "" {
+		return nil, errors.New("unix: no upstream configured")
+	}
+	if _, _, err := net.SplitHostPort(ul.Upstream); err == nil {
+		return net.Dial("tcp", ul.Upstream)
+	}
+	return net.Dial(ul.Network, ul.Upstream)
+}
+
+//Forward dials ul.Upstream and proxies conn to it bidirectionally until either side closes or
+//errors. It returns the error from copying upstream to conn if there was one, and otherwise the
+//error from copying conn to upstream. Callers drive this per accepted connection since
+//Accept itself only hands back the raw conn (there is no original destination to infer an
+//upstream from for a Unix socket).
+func (ul *UnixListener) Forward(conn net.Conn) error {
+	upstream, err := ul.DialUpstream()
+	if err != nil {
+		return err
+	}
+	defer upstream.Close()
+
+	errc := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(upstream, conn)
+		//Unblock the other direction's pending Read once this side's input is exhausted, or
+		//Forward would hang forever if only one peer closes its connection.
+		upstream.Close()
+		errc <- err
+	}()
+	_, err = io.Copy(conn, upstream)
+	conn.Close()
+	if err2 := <-errc; err == nil {
+		err = err2
+	}
+	return err
+}
@@ -0,0 +1,87 @@
+package listener
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+func TestUnixListenerDialUpstreamNoUpstream(t *testing.T) {
+	ul := &UnixListener{}
+	if _, err := ul.DialUpstream(); err == nil {
+		t.Fatal("expected an error when Upstream is unset, got nil")
+	}
+}
+
+func TestUnixListenerDialUpstreamTCP(t *testing.T) {
+	tcpLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	defer tcpLn.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := tcpLn.Accept()
+		if err == nil {
+			accepted <- c
+		}
+	}()
+
+	ul := &UnixListener{Upstream: tcpLn.Addr().String()}
+	conn, err := ul.DialUpstream()
+	if err != nil {
+		t.Fatalf("DialUpstream: %v", err)
+	}
+	defer conn.Close()
+
+	server := <-accepted
+	defer server.Close()
+}
+
+func TestUnixListenerForward(t *testing.T) {
+	tcpLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	defer tcpLn.Close()
+
+	go func() {
+		c, err := tcpLn.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		buf := make([]byte, 1024)
+		for {
+			n, err := c.Read(buf)
+			if n > 0 {
+				if _, werr := c.Write(buf[:n]); werr != nil {
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	client, accepted := net.Pipe()
+	ul := &UnixListener{Upstream: tcpLn.Addr().String()}
+
+	done := make(chan error, 1)
+	go func() { done <- ul.Forward(accepted) }()
+
+	msg := []byte("hello")
+	go client.Write(msg)
+
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(client, buf); err != nil {
+		t.Fatalf("reading echoed data: %v", err)
+	}
+	if string(buf) != "hello" {
+		t.Errorf("echoed %q, want %q", buf, "hello")
+	}
+	client.Close()
+	<-done
+}
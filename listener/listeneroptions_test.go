@@ -0,0 +1,147 @@
+package listener
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestListenerOptionsApplyNil(t *testing.T) {
+	tcpLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	defer tcpLn.Close()
+
+	conn, err := net.Dial("tcp", tcpLn.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial: %v", err)
+	}
+	defer conn.Close()
+	server, err := tcpLn.Accept()
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+	defer server.Close()
+
+	var opts *ListenerOptions
+	if err := opts.apply(server.(*net.TCPConn)); err != nil {
+		t.Fatalf("nil ListenerOptions.apply returned an error: %v", err)
+	}
+}
+
+func TestListenerOptionsApply(t *testing.T) {
+	tcpLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	defer tcpLn.Close()
+
+	conn, err := net.Dial("tcp", tcpLn.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial: %v", err)
+	}
+	defer conn.Close()
+	server, err := tcpLn.Accept()
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+	defer server.Close()
+
+	linger := 0
+	opts := &ListenerOptions{
+		KeepAlive:       true,
+		KeepAlivePeriod: time.Minute,
+		Linger:          &linger,
+		NoDelay:         true,
+	}
+	if err := opts.apply(server.(*net.TCPConn)); err != nil {
+		t.Fatalf("ListenerOptions.apply: %v", err)
+	}
+}
+
+func TestListenerOptionsWrapNoTimeouts(t *testing.T) {
+	client, _ := net.Pipe()
+	defer client.Close()
+
+	var opts *ListenerOptions
+	if got := opts.wrap(client); got != client {
+		t.Errorf("wrap() with nil Options should return the conn unwrapped, got %T", got)
+	}
+
+	opts = &ListenerOptions{}
+	if got := opts.wrap(client); got != client {
+		t.Errorf("wrap() with zero timeouts should return the conn unwrapped, got %T", got)
+	}
+}
+
+func TestListenerOptionsWrapAppliesSlidingTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	opts := &ListenerOptions{ReadTimeout: 50 * time.Millisecond}
+	wrapped := opts.wrap(server)
+	if _, ok := wrapped.(*deadlineConn); !ok {
+		t.Fatalf("wrap() with ReadTimeout set should return a *deadlineConn, got %T", wrapped)
+	}
+
+	buf := make([]byte, 1)
+	start := time.Now()
+	_, err := wrapped.Read(buf)
+	if err == nil {
+		t.Fatal("expected the sliding read timeout to fire, got nil error")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Read took %v, expected it to time out near 50ms", elapsed)
+	}
+}
+
+func TestDeadlineConnDoesNotClobberExternalDeadline(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	dc := &deadlineConn{Conn: server, readTimeout: time.Hour}
+
+	strict := time.Now().Add(50 * time.Millisecond)
+	if err := dc.SetReadDeadline(strict); err != nil {
+		t.Fatalf("SetReadDeadline: %v", err)
+	}
+
+	buf := make([]byte, 1)
+	start := time.Now()
+	_, err := dc.Read(buf)
+	if err == nil {
+		t.Fatal("expected the caller's strict deadline to fire, got nil error")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Read took %v, expected the 50ms external deadline to win over the 1h idle timeout", elapsed)
+	}
+}
+
+func TestDeadlineConnResumesSlidingTimeoutAfterExternalDeadlineCleared(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	dc := &deadlineConn{Conn: server, readTimeout: 50 * time.Millisecond}
+
+	// Simulate a wrapper (e.g. ProxyProtoListener) setting, then clearing, its own deadline.
+	if err := dc.SetReadDeadline(time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("SetReadDeadline: %v", err)
+	}
+	if err := dc.SetReadDeadline(time.Time{}); err != nil {
+		t.Fatalf("SetReadDeadline(zero): %v", err)
+	}
+
+	buf := make([]byte, 1)
+	start := time.Now()
+	_, err := dc.Read(buf)
+	if err == nil {
+		t.Fatal("expected the sliding read timeout to fire once the external deadline was cleared, got nil error")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Read took %v, expected it to time out near 50ms", elapsed)
+	}
+}
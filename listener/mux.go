@@ -0,0 +1,253 @@
+package listener
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+)
+
+//maxTLSRecordPeek is big enough to buffer a full max-size (16KB) TLS record plus its 5-byte
+//header, which is as large as a ClientHello can legally be in a single record.
+const maxTLSRecordPeek = 18 * 1024
+
+const recordTypeHandshake = 0x16
+
+//defaultMuxHeaderTimeout bounds how long MuxListener will wait for enough bytes to tell TLS from
+//plaintext apart, mirroring ProxyProtoListener's defaultProxyProtoTimeout: without it a client
+//that opens the connection and then stalls (or any plaintext protocol that waits for the server
+//to speak first) would block Accept indefinitely and wedge the accept loop.
+const defaultMuxHeaderTimeout = 500 * time.Millisecond
+
+//MuxListener wraps another TrudyListener and demultiplexes TLS from plaintext TCP on a single
+//accepted connection, similar to how an SSH/TLS demultiplexer routes on the first handshake byte.
+//Connections whose first bytes look like a TLS handshake record are peeked for their SNI/ALPN and
+//handed to tls.Server using Config (whose GetConfigForClient can select a certificate per observed
+//SNI); everything else passes through unmodified.
+type MuxListener struct {
+	Inner   TrudyListener
+	Config  *tls.Config
+	Timeout time.Duration
+}
+
+func NewMuxListener(inner TrudyListener, config *tls.Config) *MuxListener {
+	return &MuxListener{Inner: inner, Config: config, Timeout: defaultMuxHeaderTimeout}
+}
+
+func (ml *MuxListener) Listen(network, address string, cert *tls.Config) error {
+	return ml.Inner.Listen(network, address, cert)
+}
+
+func (ml *MuxListener) Close() error {
+	return ml.Inner.Close()
+}
+
+func (ml *MuxListener) Accept() (fd int, conn net.Conn, err error) {
+	fd, conn, err = ml.Inner.Accept()
+	if err != nil {
+		return
+	}
+
+	timeout := ml.Timeout
+	if timeout == 0 {
+		timeout = defaultMuxHeaderTimeout
+	}
+	if err = conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		conn.Close()
+		return 0, nil, err
+	}
+
+	r := bufio.NewReaderSize(conn, maxTLSRecordPeek)
+	header, err := r.Peek(3)
+	if err != nil {
+		conn.Close()
+		return 0, nil, err
+	}
+
+	if !looksLikeTLSHandshake(header) {
+		if err = conn.SetReadDeadline(time.Time{}); err != nil {
+			conn.Close()
+			return 0, nil, err
+		}
+		return fd, &muxConn{Conn: newPeekedConn(conn, r)}, nil
+	}
+
+	sni, alpn, err := peekClientHello(r)
+	if err != nil {
+		conn.Close()
+		return 0, nil, err
+	}
+	if err = conn.SetReadDeadline(time.Time{}); err != nil {
+		conn.Close()
+		return 0, nil, err
+	}
+
+	tlsConn := tls.Server(newPeekedConn(conn, r), ml.Config)
+	return fd, &muxConn{Conn: tlsConn, sni: sni, alpn: alpn}, nil
+}
+
+//looksLikeTLSHandshake sanity-checks the record header's ContentType and legacy protocol version
+//(TLS 1.0-1.3 all report major version 3 here) before committing to a ClientHello parse, so a
+//plaintext protocol that merely happens to start with byte 0x16 doesn't get misrouted into
+//peekClientHello and potentially hang waiting for bytes that will never come.
+func looksLikeTLSHandshake(header []byte) bool {
+	return len(header) >= 3 && header[0] == recordTypeHandshake && header[1] == 0x03 && header[2] <= 0x04
+}
+
+//muxConn is the net.Conn returned by MuxListener.Accept. It exposes the SNI host name and ALPN
+//protocols observed in the ClientHello (both empty for plaintext connections) so downstream pipe
+//modules can decide whether, and how, to MITM the connection.
+type muxConn struct {
+	net.Conn
+	sni  string
+	alpn []string
+}
+
+func (c *muxConn) SNI() string { return c.sni }
+
+func (c *muxConn) ALPN() []string { return c.alpn }
+
+//peekClientHello reads the first TLS record off r (without consuming it for anyone reading from
+//r afterwards - see peekedConn) and extracts the SNI and ALPN values from the ClientHello it
+//contains, without performing a handshake.
+func peekClientHello(r *bufio.Reader) (sni string, alpn []string, err error) {
+	header, err := r.Peek(5)
+	if err != nil {
+		return "", nil, err
+	}
+	recLen := int(header[3])<<8 | int(header[4])
+	if recLen <= 0 || 5+recLen > maxTLSRecordPeek {
+		return "", nil, errors.New("mux: ClientHello record too large to inspect")
+	}
+
+	record, err := r.Peek(5 + recLen)
+	if err != nil {
+		return "", nil, err
+	}
+	body := record[5:]
+	if len(body) < 4 || body[0] != 0x01 { // handshake type ClientHello
+		return "", nil, errors.New("mux: first handshake message is not a ClientHello")
+	}
+	hsLen := int(body[1])<<16 | int(body[2])<<8 | int(body[3])
+	if 4+hsLen > len(body) {
+		return "", nil, errors.New("mux: truncated ClientHello")
+	}
+	return parseClientHelloExtensions(body[4 : 4+hsLen])
+}
+
+func parseClientHelloExtensions(b []byte) (sni string, alpn []string, err error) {
+	if len(b) < 34 { // client_version(2) + random(32)
+		return "", nil, errors.New("mux: ClientHello too short")
+	}
+	b = b[34:]
+
+	if len(b) < 1 {
+		return "", nil, errors.New("mux: ClientHello truncated at session id")
+	}
+	sidLen := int(b[0])
+	b = b[1:]
+	if len(b) < sidLen {
+		return "", nil, errors.New("mux: ClientHello truncated in session id")
+	}
+	b = b[sidLen:]
+
+	if len(b) < 2 {
+		return "", nil, errors.New("mux: ClientHello truncated at cipher suites")
+	}
+	csLen := int(binary.BigEndian.Uint16(b[0:2]))
+	b = b[2:]
+	if len(b) < csLen {
+		return "", nil, errors.New("mux: ClientHello truncated in cipher suites")
+	}
+	b = b[csLen:]
+
+	if len(b) < 1 {
+		return "", nil, errors.New("mux: ClientHello truncated at compression methods")
+	}
+	cmLen := int(b[0])
+	b = b[1:]
+	if len(b) < cmLen {
+		return "", nil, errors.New("mux: ClientHello truncated in compression methods")
+	}
+	b = b[cmLen:]
+
+	if len(b) < 2 {
+		return "", nil, nil // no extensions present
+	}
+	extLen := int(binary.BigEndian.Uint16(b[0:2]))
+	b = b[2:]
+	if len(b) < extLen {
+		return "", nil, errors.New("mux: ClientHello truncated in extensions")
+	}
+	b = b[:extLen]
+
+	for len(b) >= 4 {
+		extType := binary.BigEndian.Uint16(b[0:2])
+		extBodyLen := int(binary.BigEndian.Uint16(b[2:4]))
+		b = b[4:]
+		if len(b) < extBodyLen {
+			return "", nil, errors.New("mux: ClientHello truncated in extension body")
+		}
+		extBody := b[:extBodyLen]
+		b = b[extBodyLen:]
+
+		switch extType {
+		case 0x0000: // server_name
+			sni = parseSNIExtension(extBody)
+		case 0x0010: // application_layer_protocol_negotiation
+			alpn = parseALPNExtension(extBody)
+		}
+	}
+	return sni, alpn, nil
+}
+
+func parseSNIExtension(b []byte) string {
+	if len(b) < 2 {
+		return ""
+	}
+	listLen := int(binary.BigEndian.Uint16(b[0:2]))
+	b = b[2:]
+	if len(b) < listLen {
+		return ""
+	}
+	b = b[:listLen]
+	for len(b) >= 3 {
+		nameType := b[0]
+		nameLen := int(binary.BigEndian.Uint16(b[1:3]))
+		b = b[3:]
+		if len(b) < nameLen {
+			return ""
+		}
+		name := string(b[:nameLen])
+		b = b[nameLen:]
+		if nameType == 0x00 { // host_name
+			return name
+		}
+	}
+	return ""
+}
+
+func parseALPNExtension(b []byte) []string {
+	if len(b) < 2 {
+		return nil
+	}
+	listLen := int(binary.BigEndian.Uint16(b[0:2]))
+	b = b[2:]
+	if len(b) < listLen {
+		return nil
+	}
+	b = b[:listLen]
+	var protos []string
+	for len(b) >= 1 {
+		protoLen := int(b[0])
+		b = b[1:]
+		if len(b) < protoLen {
+			break
+		}
+		protos = append(protos, string(b[:protoLen]))
+		b = b[protoLen:]
+	}
+	return protos
+}
@@ -0,0 +1,123 @@
+package listener
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+//clientHello builds a minimal, syntactically valid ClientHello body (the bytes that follow the
+//4-byte handshake header) carrying the given SNI host name and ALPN protocols.
+func clientHello(t *testing.T, sni string, alpn []string) []byte {
+	t.Helper()
+
+	var b []byte
+	b = append(b, 0x03, 0x03)       // client_version
+	b = append(b, make([]byte, 32)...) // random
+	b = append(b, 0x00)             // session_id length
+	b = append(b, 0x00, 0x02, 0x13, 0x01) // cipher_suites: length 2, one suite
+	b = append(b, 0x01, 0x00)       // compression_methods: length 1, null
+
+	var extensions []byte
+	if sni != "" {
+		name := []byte(sni)
+		var ext []byte
+		ext = append(ext, 0x00)                               // name_type: host_name
+		ext = appendUint16(ext, uint16(len(name)))
+		ext = append(ext, name...)
+		var list []byte
+		list = appendUint16(list, uint16(len(ext)))
+		list = append(list, ext...)
+		extensions = appendExtension(extensions, 0x0000, list)
+	}
+	if len(alpn) > 0 {
+		var protos []byte
+		for _, p := range alpn {
+			protos = append(protos, byte(len(p)))
+			protos = append(protos, []byte(p)...)
+		}
+		var list []byte
+		list = appendUint16(list, uint16(len(protos)))
+		list = append(list, protos...)
+		extensions = appendExtension(extensions, 0x0010, list)
+	}
+
+	b = appendUint16(b, uint16(len(extensions)))
+	b = append(b, extensions...)
+	return b
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	buf := make([]byte, 2)
+	binary.BigEndian.PutUint16(buf, v)
+	return append(b, buf...)
+}
+
+func appendExtension(b []byte, typ uint16, body []byte) []byte {
+	b = appendUint16(b, typ)
+	b = appendUint16(b, uint16(len(body)))
+	return append(b, body...)
+}
+
+func TestParseClientHelloExtensionsSNIAndALPN(t *testing.T) {
+	body := clientHello(t, "example.com", []string{"h2", "http/1.1"})
+
+	sni, alpn, err := parseClientHelloExtensions(body)
+	if err != nil {
+		t.Fatalf("parseClientHelloExtensions: %v", err)
+	}
+	if sni != "example.com" {
+		t.Errorf("sni = %q, want %q", sni, "example.com")
+	}
+	if len(alpn) != 2 || alpn[0] != "h2" || alpn[1] != "http/1.1" {
+		t.Errorf("alpn = %v, want [h2 http/1.1]", alpn)
+	}
+}
+
+func TestParseClientHelloExtensionsNoExtensions(t *testing.T) {
+	body := clientHello(t, "", nil)
+
+	sni, alpn, err := parseClientHelloExtensions(body)
+	if err != nil {
+		t.Fatalf("parseClientHelloExtensions: %v", err)
+	}
+	if sni != "" || alpn != nil {
+		t.Errorf("expected no SNI/ALPN, got sni=%q alpn=%v", sni, alpn)
+	}
+}
+
+func TestParseClientHelloExtensionsTruncated(t *testing.T) {
+	body := clientHello(t, "example.com", nil)
+	truncated := body[:len(body)-4]
+
+	if _, _, err := parseClientHelloExtensions(truncated); err == nil {
+		t.Fatal("expected an error for a truncated ClientHello, got nil")
+	}
+}
+
+func TestParseClientHelloExtensionsTooShort(t *testing.T) {
+	if _, _, err := parseClientHelloExtensions([]byte{0x03, 0x03}); err == nil {
+		t.Fatal("expected an error for a too-short ClientHello, got nil")
+	}
+}
+
+func TestLooksLikeTLSHandshake(t *testing.T) {
+	cases := []struct {
+		name   string
+		header []byte
+		want   bool
+	}{
+		{"valid TLS 1.2 record", []byte{0x16, 0x03, 0x03}, true},
+		{"valid TLS 1.0 record", []byte{0x16, 0x03, 0x01}, true},
+		{"wrong content type", []byte{0x17, 0x03, 0x03}, false},
+		{"implausible minor version", []byte{0x16, 0x03, 0xff}, false},
+		{"plaintext HTTP", []byte("GET"), false},
+		{"too short", []byte{0x16, 0x03}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := looksLikeTLSHandshake(c.header); got != c.want {
+				t.Errorf("looksLikeTLSHandshake(%v) = %v, want %v", c.header, got, c.want)
+			}
+		})
+	}
+}
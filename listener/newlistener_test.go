@@ -0,0 +1,74 @@
+package listener
+
+import "testing"
+
+func TestNewListenerDispatch(t *testing.T) {
+	cases := []struct {
+		network string
+		addr    string
+		want    interface{}
+	}{
+		{"tcp", "127.0.0.1:0", &TCPListener{}},
+		{"tcp4", "127.0.0.1:0", &TCPListener{}},
+		{"tcp6", "[::1]:0", &TCPListener{}},
+		{"udp", "127.0.0.1:0", &UDPListener{}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.network, func(t *testing.T) {
+			tl, err := NewListener(c.network, c.addr, nil, nil, "")
+			if err != nil {
+				t.Fatalf("NewListener(%q, ...): %v", c.network, err)
+			}
+			defer tl.Close()
+
+			switch c.want.(type) {
+			case *TCPListener:
+				if _, ok := tl.(*TCPListener); !ok {
+					t.Errorf("NewListener(%q, ...) = %T, want *TCPListener", c.network, tl)
+				}
+			case *UDPListener:
+				if _, ok := tl.(*UDPListener); !ok {
+					t.Errorf("NewListener(%q, ...) = %T, want *UDPListener", c.network, tl)
+				}
+			}
+		})
+	}
+}
+
+func TestNewListenerUnixDispatchAndUpstream(t *testing.T) {
+	dir := t.TempDir()
+	path := dir + "/trudy.sock"
+
+	tl, err := NewListener("unix", path, nil, nil, "/var/run/docker.sock")
+	if err != nil {
+		t.Fatalf("NewListener(unix, ...): %v", err)
+	}
+	defer tl.Close()
+
+	ul, ok := tl.(*UnixListener)
+	if !ok {
+		t.Fatalf("NewListener(unix, ...) = %T, want *UnixListener", tl)
+	}
+	if ul.Upstream != "/var/run/docker.sock" {
+		t.Errorf("Upstream = %q, want %q", ul.Upstream, "/var/run/docker.sock")
+	}
+}
+
+func TestNewListenerUnsupportedNetwork(t *testing.T) {
+	if _, err := NewListener("sctp", "127.0.0.1:0", nil, nil, ""); err == nil {
+		t.Fatal("expected an error for an unsupported network, got nil")
+	}
+}
+
+func TestNewListenerPropagatesResolveError(t *testing.T) {
+	if _, err := NewListener("tcp", "not a valid address", nil, nil, ""); err == nil {
+		t.Fatal("expected an error for an unresolvable address, got nil")
+	}
+}
+
+func TestNewListenerTLSRequiresCertificate(t *testing.T) {
+	if _, err := NewListener("tls", "127.0.0.1:0", nil, nil, ""); err == nil {
+		t.Fatal("expected an error when no TLS config/certificate is supplied, got nil")
+	}
+}
@@ -0,0 +1,237 @@
+package listener
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//defaultProxyProtoTimeout bounds how long ProxyProtoListener will wait for a PROXY protocol
+//header before giving up, so a client that never sends one can't hang the accept loop.
+const defaultProxyProtoTimeout = 500 * time.Millisecond
+
+var proxyProtoV2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+//PROXY protocol v2 TLV types we care about. PP2TypeTrudy is a custom trudy-specific TLV (in the
+//experimental 0xE0-0xEF range) that upstream proxies/load balancers can set to pass extra routing
+//hints through to trudy modules.
+const (
+	PP2TypeAuthority = 0x02
+	PP2TypeSSL       = 0x20
+	PP2TypeTrudy     = 0xE4
+)
+
+//ProxyProtoListener wraps another TrudyListener and expects a HAProxy PROXY protocol (v1 or v2)
+//header at the start of every accepted connection, replacing the connection's apparent source
+//address with the real client address declared in the header. This lets trudy sit behind a load
+//balancer or cloud NLB that performs NAT and still attribute connections to the true client.
+type ProxyProtoListener struct {
+	Inner   TrudyListener
+	Timeout time.Duration
+}
+
+//NewProxyProtoListener wraps inner with PROXY protocol parsing using the default header timeout.
+func NewProxyProtoListener(inner TrudyListener) *ProxyProtoListener {
+	return &ProxyProtoListener{Inner: inner, Timeout: defaultProxyProtoTimeout}
+}
+
+func (pl *ProxyProtoListener) Listen(network, address string, cert *tls.Config) error {
+	return pl.Inner.Listen(network, address, cert)
+}
+
+func (pl *ProxyProtoListener) Accept() (fd int, conn net.Conn, err error) {
+	fd, conn, err = pl.Inner.Accept()
+	if err != nil {
+		return
+	}
+	wrapped, err := pl.readHeader(conn)
+	if err != nil {
+		conn.Close()
+		return 0, nil, err
+	}
+	return fd, wrapped, nil
+}
+
+func (pl *ProxyProtoListener) Close() error {
+	return pl.Inner.Close()
+}
+
+func (pl *ProxyProtoListener) readHeader(conn net.Conn) (net.Conn, error) {
+	timeout := pl.Timeout
+	if timeout == 0 {
+		timeout = defaultProxyProtoTimeout
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	defer conn.SetReadDeadline(time.Time{})
+
+	r := bufio.NewReader(conn)
+
+	if sig, err := r.Peek(len(proxyProtoV2Signature)); err == nil && bytes.Equal(sig, proxyProtoV2Signature) {
+		return pl.readV2(conn, r)
+	}
+	if prefix, err := r.Peek(6); err == nil && string(prefix) == "PROXY " {
+		return pl.readV1(conn, r)
+	}
+	return nil, errors.New("proxyproto: unrecognized header signature")
+}
+
+func (pl *ProxyProtoListener) readV1(conn net.Conn, r *bufio.Reader) (net.Conn, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if len(line) > 107 {
+		return nil, errors.New("proxyproto: v1 header exceeds maximum length")
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return nil, fmt.Errorf("proxyproto: malformed v1 header %q", line)
+	}
+
+	pc := &proxyProtoConn{Conn: newPeekedConn(conn, r)}
+	if fields[1] == "UNKNOWN" {
+		return pc, nil
+	}
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("proxyproto: malformed v1 header %q", line)
+	}
+
+	srcIP := net.ParseIP(fields[2])
+	dstIP := net.ParseIP(fields[3])
+	srcPort, err := strconv.Atoi(fields[4])
+	if err != nil {
+		return nil, fmt.Errorf("proxyproto: invalid source port in v1 header: %v", err)
+	}
+	dstPort, err := strconv.Atoi(fields[5])
+	if err != nil {
+		return nil, fmt.Errorf("proxyproto: invalid destination port in v1 header: %v", err)
+	}
+	if srcIP == nil || dstIP == nil {
+		return nil, fmt.Errorf("proxyproto: invalid address in v1 header %q", line)
+	}
+
+	pc.srcAddr = &net.TCPAddr{IP: srcIP, Port: srcPort}
+	pc.dstAddr = &net.TCPAddr{IP: dstIP, Port: dstPort}
+	return pc, nil
+}
+
+func (pl *ProxyProtoListener) readV2(conn net.Conn, r *bufio.Reader) (net.Conn, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	verCmd := header[12]
+	if verCmd>>4 != 2 {
+		return nil, fmt.Errorf("proxyproto: unsupported v2 version %d", verCmd>>4)
+	}
+	cmd := verCmd & 0x0F
+	family := header[13] >> 4
+	length := binary.BigEndian.Uint16(header[14:16])
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	pc := &proxyProtoConn{Conn: newPeekedConn(conn, r), tlvs: make(map[byte][]byte)}
+	if cmd == 0x00 { // LOCAL: health check, no real client address to report.
+		return pc, nil
+	}
+
+	var addrLen int
+	switch family {
+	case 0x1: // AF_INET
+		addrLen = 12
+		if len(body) < addrLen {
+			return nil, errors.New("proxyproto: truncated v2 TCP4 address block")
+		}
+		pc.srcAddr = &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:10]))}
+		pc.dstAddr = &net.TCPAddr{IP: net.IP(body[4:8]), Port: int(binary.BigEndian.Uint16(body[10:12]))}
+	case 0x2: // AF_INET6
+		addrLen = 36
+		if len(body) < addrLen {
+			return nil, errors.New("proxyproto: truncated v2 TCP6 address block")
+		}
+		pc.srcAddr = &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:34]))}
+		pc.dstAddr = &net.TCPAddr{IP: net.IP(body[16:32]), Port: int(binary.BigEndian.Uint16(body[34:36]))}
+	case 0x3: // AF_UNIX
+		addrLen = 216
+		if len(body) < addrLen {
+			return nil, errors.New("proxyproto: truncated v2 UNIX address block")
+		}
+		pc.srcAddr = &net.UnixAddr{Net: "unix", Name: trimNUL(body[0:108])}
+		pc.dstAddr = &net.UnixAddr{Net: "unix", Name: trimNUL(body[108:216])}
+	default:
+		return nil, fmt.Errorf("proxyproto: unsupported v2 address family %d", family)
+	}
+
+	if err := pc.parseTLVs(body[addrLen:]); err != nil {
+		return nil, err
+	}
+	return pc, nil
+}
+
+func trimNUL(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+//proxyProtoConn is a net.Conn whose RemoteAddr reflects the real client address parsed from a
+//PROXY protocol header instead of the address of the (possibly load-balanced) peer trudy accepted
+//the connection from.
+type proxyProtoConn struct {
+	net.Conn
+	srcAddr net.Addr
+	dstAddr net.Addr
+	tlvs    map[byte][]byte
+}
+
+func (c *proxyProtoConn) RemoteAddr() net.Addr {
+	if c.srcAddr != nil {
+		return c.srcAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+//OriginalDst returns the destination address declared in the PROXY protocol header, if any.
+func (c *proxyProtoConn) OriginalDst() net.Addr {
+	return c.dstAddr
+}
+
+//TLV returns the value of the v2 TLV of the given type, if the header carried one.
+func (c *proxyProtoConn) TLV(t byte) ([]byte, bool) {
+	v, ok := c.tlvs[t]
+	return v, ok
+}
+
+func (c *proxyProtoConn) parseTLVs(b []byte) error {
+	for len(b) > 0 {
+		if len(b) < 3 {
+			return errors.New("proxyproto: truncated TLV header")
+		}
+		t := b[0]
+		l := int(binary.BigEndian.Uint16(b[1:3]))
+		b = b[3:]
+		if len(b) < l {
+			return errors.New("proxyproto: truncated TLV value")
+		}
+		c.tlvs[t] = b[:l]
+		b = b[l:]
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package listener
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestProxyProtoReadV1TCP4(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	go client.Write([]byte("PROXY TCP4 192.168.1.1 192.168.1.2 11111 22222\r\n"))
+
+	pl := &ProxyProtoListener{Timeout: time.Second}
+	conn, err := pl.readHeader(server)
+	if err != nil {
+		t.Fatalf("readHeader: %v", err)
+	}
+	defer conn.Close()
+
+	pc, ok := conn.(*proxyProtoConn)
+	if !ok {
+		t.Fatalf("expected *proxyProtoConn, got %T", conn)
+	}
+	if got := pc.RemoteAddr().String(); got != "192.168.1.1:11111" {
+		t.Errorf("RemoteAddr() = %q, want %q", got, "192.168.1.1:11111")
+	}
+	if got := pc.OriginalDst().String(); got != "192.168.1.2:22222" {
+		t.Errorf("OriginalDst() = %q, want %q", got, "192.168.1.2:22222")
+	}
+}
+
+func TestProxyProtoReadV1Unknown(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	go client.Write([]byte("PROXY UNKNOWN\r\n"))
+
+	pl := &ProxyProtoListener{Timeout: time.Second}
+	conn, err := pl.readHeader(server)
+	if err != nil {
+		t.Fatalf("readHeader: %v", err)
+	}
+	defer conn.Close()
+
+	pc, ok := conn.(*proxyProtoConn)
+	if !ok {
+		t.Fatalf("expected *proxyProtoConn, got %T", conn)
+	}
+	if pc.srcAddr != nil {
+		t.Errorf("srcAddr = %v, want nil for UNKNOWN", pc.srcAddr)
+	}
+}
+
+func TestProxyProtoReadV1Malformed(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	go client.Write([]byte("PROXY TCP4 not-an-ip\r\n"))
+
+	pl := &ProxyProtoListener{Timeout: time.Second}
+	if _, err := pl.readHeader(server); err == nil {
+		t.Fatal("expected an error for a malformed v1 header, got nil")
+	}
+}
+
+func TestProxyProtoReadV2TCP4(t *testing.T) {
+	header := make([]byte, 16)
+	copy(header, proxyProtoV2Signature)
+	header[12] = 0x21 // version 2, command PROXY
+	header[13] = 0x11 // AF_INET, STREAM
+	binary.BigEndian.PutUint16(header[14:16], 12)
+
+	body := make([]byte, 12)
+	copy(body[0:4], net.ParseIP("10.0.0.1").To4())
+	copy(body[4:8], net.ParseIP("10.0.0.2").To4())
+	binary.BigEndian.PutUint16(body[8:10], 1111)
+	binary.BigEndian.PutUint16(body[10:12], 2222)
+
+	client, server := net.Pipe()
+	defer client.Close()
+
+	go func() {
+		client.Write(header)
+		client.Write(body)
+	}()
+
+	pl := &ProxyProtoListener{Timeout: time.Second}
+	conn, err := pl.readHeader(server)
+	if err != nil {
+		t.Fatalf("readHeader: %v", err)
+	}
+	defer conn.Close()
+
+	pc, ok := conn.(*proxyProtoConn)
+	if !ok {
+		t.Fatalf("expected *proxyProtoConn, got %T", conn)
+	}
+	if got := pc.RemoteAddr().String(); got != "10.0.0.1:1111" {
+		t.Errorf("RemoteAddr() = %q, want %q", got, "10.0.0.1:1111")
+	}
+}
+
+func TestProxyProtoReadV2TruncatedBody(t *testing.T) {
+	header := make([]byte, 16)
+	copy(header, proxyProtoV2Signature)
+	header[12] = 0x21
+	header[13] = 0x11
+	binary.BigEndian.PutUint16(header[14:16], 12)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		client.Write(header)
+		client.Write([]byte{0x0a, 0x0b}) // only 2 of the promised 12 body bytes
+		client.Close()
+	}()
+
+	pl := &ProxyProtoListener{Timeout: time.Second}
+	if _, err := pl.readHeader(server); err == nil {
+		t.Fatal("expected an error for a truncated v2 body, got nil")
+	}
+}
+
+func TestProxyProtoReadV2BadVersion(t *testing.T) {
+	header := make([]byte, 16)
+	copy(header, proxyProtoV2Signature)
+	header[12] = 0x11 // version 1 (invalid, only version 2 is defined)
+	header[13] = 0x11
+	binary.BigEndian.PutUint16(header[14:16], 0)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	go client.Write(header)
+
+	pl := &ProxyProtoListener{Timeout: time.Second}
+	if _, err := pl.readHeader(server); err == nil {
+		t.Fatal("expected an error for an unsupported v2 version, got nil")
+	}
+}
+
+func TestProxyProtoReadUnrecognizedSignature(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	go client.Write([]byte("GET / HTTP/1.1\r\n"))
+
+	pl := &ProxyProtoListener{Timeout: time.Second}
+	if _, err := pl.readHeader(server); err == nil {
+		t.Fatal("expected an error for an unrecognized signature, got nil")
+	}
+}
+
+func TestProxyProtoReadHeaderTimeout(t *testing.T) {
+	_, server := net.Pipe()
+	defer server.Close()
+
+	pl := &ProxyProtoListener{Timeout: 50 * time.Millisecond}
+	if _, err := pl.readHeader(server); err == nil {
+		t.Fatal("expected a timeout error when no header is ever sent, got nil")
+	}
+}
@@ -0,0 +1,208 @@
+package listener
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+//Environment variables used to hand tracked listener sockets from one trudy process to the next
+//across a graceful reload. TRUDY_INHERITED_FDS is a comma-separated list of file descriptor
+//numbers; TRUDY_INHERITED_NETS is the parallel comma-separated list of "network:address" specs
+//(e.g. "tcp:0.0.0.0:443").
+const (
+	envInheritedFDs  = "TRUDY_INHERITED_FDS"
+	envInheritedNets = "TRUDY_INHERITED_NETS"
+)
+
+//ReloadManager tracks the listeners a running trudy process owns so they can be handed off,
+//without ever releasing their ports, to a freshly exec'd copy of the binary when Reload is
+//called. ReloadManager does not itself listen for SIGHUP, SIGUSR2 or any other signal.
+type ReloadManager struct {
+	mu         sync.Mutex
+	specs      []*reloadSpec
+	onShutdown []func()
+
+	//DrainTimeout bounds how long Reload waits for ActiveConns to reach zero before exec'ing the
+	//new binary. Zero means don't wait.
+	DrainTimeout time.Duration
+
+	//ActiveConns, if set, is polled by Reload to learn how many in-flight pipes still need to
+	//drain before the old process hands off.
+	ActiveConns func() int
+}
+
+type reloadSpec struct {
+	network  string
+	address  string
+	listener TrudyListener
+	file     *os.File
+}
+
+func NewReloadManager() *ReloadManager {
+	return &ReloadManager{DrainTimeout: 30 * time.Second}
+}
+
+//Track registers tl (a *TCPListener or *TLSListener bound via network/address) so Reload can
+//export its file descriptor to the next process.
+func (rm *ReloadManager) Track(network, address string, tl TrudyListener) error {
+	file, err := listenerFile(tl)
+	if err != nil {
+		return err
+	}
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rm.specs = append(rm.specs, &reloadSpec{network: network, address: address, listener: tl, file: file})
+	return nil
+}
+
+//OnShutdown registers fn to run just before Reload hands off to the new process, so callers can
+//rotate TLS certs or flush state without dropping any tracked socket.
+func (rm *ReloadManager) OnShutdown(fn func()) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rm.onShutdown = append(rm.onShutdown, fn)
+}
+
+//Reload exports every tracked listener's fd with FD_CLOEXEC cleared, publishes them via
+//TRUDY_INHERITED_FDS/TRUDY_INHERITED_NETS, waits for in-flight connections to drain, then
+//syscall.Execs the current binary so the new process can pick the sockets back up through
+//InheritedListeners instead of rebinding them.
+func (rm *ReloadManager) Reload() error {
+	hooks, err := rm.exportEnv()
+	if err != nil {
+		return err
+	}
+
+	for _, fn := range hooks {
+		fn()
+	}
+
+	rm.drain()
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	return syscall.Exec(exe, os.Args, os.Environ())
+}
+
+//exportEnv clears FD_CLOEXEC on every tracked listener's fd and publishes TRUDY_INHERITED_FDS/
+//TRUDY_INHERITED_NETS for the next process to pick up, returning the registered shutdown hooks.
+//Split out from Reload so the env-publishing step can be tested without actually exec'ing.
+func (rm *ReloadManager) exportEnv() ([]func(), error) {
+	rm.mu.Lock()
+	specs := append([]*reloadSpec(nil), rm.specs...)
+	hooks := append([]func(){}, rm.onShutdown...)
+	rm.mu.Unlock()
+
+	fds := make([]string, len(specs))
+	nets := make([]string, len(specs))
+	for i, s := range specs {
+		if err := clearCloseOnExec(s.file); err != nil {
+			return nil, fmt.Errorf("reload: clearing close-on-exec for %s:%s: %v", s.network, s.address, err)
+		}
+		fds[i] = strconv.Itoa(int(s.file.Fd()))
+		nets[i] = fmt.Sprintf("%s:%s", s.network, s.address)
+	}
+	if err := os.Setenv(envInheritedFDs, strings.Join(fds, ",")); err != nil {
+		return nil, err
+	}
+	if err := os.Setenv(envInheritedNets, strings.Join(nets, ",")); err != nil {
+		return nil, err
+	}
+	return hooks, nil
+}
+
+func (rm *ReloadManager) drain() {
+	if rm.ActiveConns == nil || rm.DrainTimeout == 0 {
+		return
+	}
+	deadline := time.Now().Add(rm.DrainTimeout)
+	for time.Now().Before(deadline) {
+		if rm.ActiveConns() == 0 {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func listenerFile(tl TrudyListener) (*os.File, error) {
+	switch l := tl.(type) {
+	case *TCPListener:
+		return l.Listener.File()
+	case *TLSListener:
+		return l.Listener.File()
+	default:
+		return nil, fmt.Errorf("reload: listener type %T does not support fd inheritance", tl)
+	}
+}
+
+//clearCloseOnExec clears FD_CLOEXEC on f so it survives across syscall.Exec.
+func clearCloseOnExec(f *os.File) error {
+	fd := int(f.Fd())
+	_, _, errno := syscall.Syscall(syscall.SYS_FCNTL, uintptr(fd), syscall.F_SETFD, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+//InheritedListeners checks for TRUDY_INHERITED_FDS/TRUDY_INHERITED_NETS (set by a parent trudy
+//process calling Reload) and, if present, reconstructs the corresponding TrudyListeners via
+//net.FileListener instead of binding fresh sockets. It returns nil, nil if no inherited listeners
+//are present. TLSListeners are returned without a Config set; callers must attach one before
+//calling Accept.
+func InheritedListeners() (map[string]TrudyListener, error) {
+	fdList := os.Getenv(envInheritedFDs)
+	netList := os.Getenv(envInheritedNets)
+	if fdList == "" || netList == "" {
+		return nil, nil
+	}
+
+	fdStrs := strings.Split(fdList, ",")
+	netStrs := strings.Split(netList, ",")
+	if len(fdStrs) != len(netStrs) {
+		return nil, fmt.Errorf("reload: %s and %s have mismatched lengths", envInheritedFDs, envInheritedNets)
+	}
+
+	out := make(map[string]TrudyListener, len(fdStrs))
+	for i, fdStr := range fdStrs {
+		fd, err := strconv.Atoi(fdStr)
+		if err != nil {
+			return nil, fmt.Errorf("reload: invalid fd %q: %v", fdStr, err)
+		}
+		parts := strings.SplitN(netStrs[i], ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("reload: malformed network spec %q", netStrs[i])
+		}
+		network, address := parts[0], parts[1]
+
+		file := os.NewFile(uintptr(fd), address)
+		ln, err := net.FileListener(file)
+		if err != nil {
+			return nil, fmt.Errorf("reload: reconstructing listener for fd %d: %v", fd, err)
+		}
+		file.Close()
+
+		tcpLn, ok := ln.(*net.TCPListener)
+		if !ok {
+			return nil, fmt.Errorf("reload: inherited fd %d is not a TCP listener", fd)
+		}
+
+		switch network {
+		case "tcp", "tcp4", "tcp6":
+			out[netStrs[i]] = &TCPListener{Listener: tcpLn}
+		case "tls":
+			out[netStrs[i]] = &TLSListener{Listener: tcpLn}
+		default:
+			return nil, fmt.Errorf("reload: network %q does not support fd inheritance", network)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,157 @@
+package listener
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func clearInheritedEnv(t *testing.T) {
+	t.Helper()
+	os.Unsetenv(envInheritedFDs)
+	os.Unsetenv(envInheritedNets)
+	t.Cleanup(func() {
+		os.Unsetenv(envInheritedFDs)
+		os.Unsetenv(envInheritedNets)
+	})
+}
+
+func TestReloadManagerTrackExportInheritRoundTrip(t *testing.T) {
+	clearInheritedEnv(t)
+
+	tcpLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	defer tcpLn.Close()
+
+	tl := &TCPListener{Listener: tcpLn.(*net.TCPListener)}
+	addr := tcpLn.Addr().String()
+
+	rm := NewReloadManager()
+	if err := rm.Track("tcp", addr, tl); err != nil {
+		t.Fatalf("Track: %v", err)
+	}
+
+	if _, err := rm.exportEnv(); err != nil {
+		t.Fatalf("exportEnv: %v", err)
+	}
+
+	if os.Getenv(envInheritedFDs) == "" || os.Getenv(envInheritedNets) == "" {
+		t.Fatal("exportEnv did not set the inherited-fd environment variables")
+	}
+
+	inherited, err := InheritedListeners()
+	if err != nil {
+		t.Fatalf("InheritedListeners: %v", err)
+	}
+
+	got, ok := inherited["tcp:"+addr]
+	if !ok {
+		t.Fatalf("InheritedListeners() = %v, missing key %q", inherited, "tcp:"+addr)
+	}
+	if _, ok := got.(*TCPListener); !ok {
+		t.Errorf("inherited listener = %T, want *TCPListener", got)
+	}
+}
+
+func TestReloadManagerExportEnvReturnsShutdownHooks(t *testing.T) {
+	clearInheritedEnv(t)
+
+	tcpLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	defer tcpLn.Close()
+
+	rm := NewReloadManager()
+	if err := rm.Track("tcp", tcpLn.Addr().String(), &TCPListener{Listener: tcpLn.(*net.TCPListener)}); err != nil {
+		t.Fatalf("Track: %v", err)
+	}
+
+	called := false
+	rm.OnShutdown(func() { called = true })
+
+	hooks, err := rm.exportEnv()
+	if err != nil {
+		t.Fatalf("exportEnv: %v", err)
+	}
+	for _, fn := range hooks {
+		fn()
+	}
+	if !called {
+		t.Error("expected the registered OnShutdown hook to be returned and callable")
+	}
+}
+
+func TestReloadManagerTrackRejectsUnsupportedListener(t *testing.T) {
+	rm := NewReloadManager()
+	if err := rm.Track("udp", "127.0.0.1:0", &UDPListener{}); err == nil {
+		t.Fatal("expected Track to reject a listener type that has no backing fd, got nil")
+	}
+}
+
+func TestInheritedListenersAbsentEnv(t *testing.T) {
+	clearInheritedEnv(t)
+
+	inherited, err := InheritedListeners()
+	if err != nil {
+		t.Fatalf("InheritedListeners: %v", err)
+	}
+	if inherited != nil {
+		t.Errorf("InheritedListeners() = %v, want nil when no env vars are set", inherited)
+	}
+}
+
+func TestInheritedListenersMismatchedLengths(t *testing.T) {
+	clearInheritedEnv(t)
+	os.Setenv(envInheritedFDs, "3,4")
+	os.Setenv(envInheritedNets, "tcp:0.0.0.0:443")
+
+	if _, err := InheritedListeners(); err == nil {
+		t.Fatal("expected an error for mismatched fd/net list lengths, got nil")
+	}
+}
+
+func TestInheritedListenersMalformedSpec(t *testing.T) {
+	clearInheritedEnv(t)
+	os.Setenv(envInheritedFDs, "3")
+	os.Setenv(envInheritedNets, "not-a-valid-spec")
+
+	if _, err := InheritedListeners(); err == nil {
+		t.Fatal("expected an error for a malformed network:address spec, got nil")
+	}
+}
+
+func TestInheritedListenersInvalidFD(t *testing.T) {
+	clearInheritedEnv(t)
+	os.Setenv(envInheritedFDs, "not-a-number")
+	os.Setenv(envInheritedNets, "tcp:0.0.0.0:443")
+
+	if _, err := InheritedListeners(); err == nil {
+		t.Fatal("expected an error for a non-numeric fd, got nil")
+	}
+}
+
+func TestInheritedListenersUnsupportedNetwork(t *testing.T) {
+	clearInheritedEnv(t)
+
+	tcpLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	defer tcpLn.Close()
+	file, err := tcpLn.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatalf("File: %v", err)
+	}
+	defer file.Close()
+
+	os.Setenv(envInheritedFDs, strconv.Itoa(int(file.Fd())))
+	os.Setenv(envInheritedNets, "udp:"+tcpLn.Addr().String())
+
+	if _, err := InheritedListeners(); err == nil {
+		t.Fatal("expected an error for a network that doesn't support fd inheritance, got nil")
+	}
+}